@@ -0,0 +1,42 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// openCgroupPath opens a cgroup v2 directory with O_PATH so its fd can be
+// passed to perf_event_open as the cgroup to scope counters to, per the
+// PERF_FLAG_PID_CGROUP convention (see man perf_event_open(2)).
+func openCgroupPath(path string) (int, error) {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return -1, fmt.Errorf("opening cgroup %q: %w", path, err)
+	}
+	return fd, nil
+}
+
+// cgroupID returns the stable handle cgroup2 assigns to path, obtained via
+// name_to_handle_at(2). This is the value we store in the cgroup_id column
+// so that rows collected in cgroup-scoped mode can be joined back to the
+// cgroup that produced them even if it is later renamed.
+func cgroupID(path string) (uint64, error) {
+	handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+	if err != nil {
+		return 0, fmt.Errorf("name_to_handle_at %q: %w", path, err)
+	}
+
+	bytes := handle.Bytes()
+	if len(bytes) < 8 {
+		return 0, fmt.Errorf("name_to_handle_at %q: unexpectedly short file handle (%d bytes)", path, len(bytes))
+	}
+
+	var id uint64
+	for i := 0; i < 8; i++ {
+		id |= uint64(bytes[i]) << (8 * i)
+	}
+	return id, nil
+}
@@ -0,0 +1,93 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCgroupIDIsStableAndDiffersBetweenDirectories(t *testing.T) {
+	a := t.TempDir()
+	b := t.TempDir()
+
+	idA1, err := cgroupID(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idA2, err := cgroupID(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idA1 != idA2 {
+		t.Fatalf("expected cgroupID(%q) to be stable across calls, got %d then %d", a, idA1, idA2)
+	}
+
+	idB, err := cgroupID(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idA1 == idB {
+		t.Fatalf("expected different directories to get different ids, both got %d", idA1)
+	}
+}
+
+func TestCgroupIDSurvivesRename(t *testing.T) {
+	dir := t.TempDir()
+	orig := filepath.Join(dir, "orig")
+	if err := os.Mkdir(orig, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := cgroupID(orig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := filepath.Join(dir, "renamed")
+	if err := os.Rename(orig, renamed); err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := cgroupID(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if before != after {
+		t.Fatalf("expected cgroupID to survive a rename, got %d before and %d after", before, after)
+	}
+}
+
+func TestCgroupIDNonexistentPath(t *testing.T) {
+	if _, err := cgroupID(filepath.Join(t.TempDir(), "does-not-exist")); err == nil {
+		t.Fatal("expected an error for a nonexistent path")
+	}
+}
+
+func TestOpenCgroupPathReturnsUsableFD(t *testing.T) {
+	dir := t.TempDir()
+
+	fd, err := openCgroupPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fd)
+
+	var stat unix.Stat_t
+	if err := unix.Fstat(fd, &stat); err != nil {
+		t.Fatalf("expected the returned fd to be usable with fstat: %v", err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+		t.Fatalf("expected the opened path to be a directory, got mode %o", stat.Mode)
+	}
+}
+
+func TestOpenCgroupPathNonexistent(t *testing.T) {
+	if _, err := openCgroupPath(filepath.Join(t.TempDir(), "does-not-exist")); err == nil {
+		t.Fatal("expected an error for a nonexistent path")
+	}
+}
@@ -3,108 +3,346 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"runtime"
 	"sync"
+	"unsafe"
 
 	"github.com/cilium/ebpf"
 	"golang.org/x/sys/unix"
 )
 
-// EventOpener manages perf event file descriptors for hardware events
+// EventOpener manages a group of perf event file descriptors, one group per
+// CPU. The first attr passed to NewEventOpener is the group leader; every
+// other attr is opened as a follower of that leader via group_fd, so a
+// single read of the leader's fd (PERF_FORMAT_GROUP) returns every counter
+// in the group sharing one enabled/running ratio. This keeps the counters
+// from drifting relative to each other when the kernel multiplexes them.
+//
+// The leader fd for each CPU is stored in the BPF array map passed to
+// NewEventOpener, and PutFollower can store any other follower's fd in its
+// own map, so a BPF program can read a single counter by CPU.
+//
+// Ideally the taskCounter BPF program would read the whole group
+// atomically via bpf_perf_event_read_value on the leader, at the same
+// point it samples each per-task delta, so enabled/running is exact for
+// the interval being measured. No eBPF/C source exists anywhere in this
+// tree to do that, so ReadRatios instead reads the group from userspace
+// (see main.go) and applies its lifetime-cumulative ratio uniformly to
+// every delta in a batch. That's only an approximation: it assumes the
+// ratio has held steady since the group was opened, not just during the
+// sampled window, and the three counters are never read atomically at
+// the moment they're measured. It should be replaced with the
+// kernel-side read once a BPF program exists to host it.
 type EventOpener struct {
-	mu       sync.Mutex
-	array    *ebpf.Map
-	eventFDs []int
+	mu        sync.Mutex
+	array     *ebpf.Map
+	cgroupFD  int        // -1 if the group is not cgroup-scoped
+	leaderFDs []int      // one leader fd per CPU
+	groupFDs  [][]int    // per-CPU fds in the group, leader first
+	eventIDs  [][]uint64 // per-CPU PERF_FORMAT_ID ids, aligned with groupFDs
 }
 
-// PerfEventAttr represents perf_event_attr structure
-type PerfEventAttr struct {
-	Type        uint32
-	Size        uint32
-	Config      uint64
-	Disabled    uint32
-	ExcludeKernel uint32
-	ExcludeHv   uint32
+// EventOpenerOptions controls how a group's events are scoped.
+type EventOpenerOptions struct {
+	// Cgroup, if non-empty, is the path to a cgroup v2 directory. Every
+	// event in the group is scoped to that cgroup via PERF_FLAG_PID_CGROUP
+	// instead of to "all tasks on this CPU". This is what lets the
+	// collector run scoped to a single Kubernetes pod or systemd slice
+	// rather than the whole system.
+	Cgroup string
+
+	// Inherit sets PERF_BIT_INHERIT so counters follow children forked by
+	// tasks in the cgroup/pid after the event was opened.
+	Inherit bool
 }
 
-// NewEventOpener creates perf events for CPU cycles on each CPU
-func NewEventOpener(array *ebpf.Map) (*EventOpener, error) {
+// groupReadFormat is OR'd into every attr in the group so that reading the
+// leader returns the whole group, each event's stable ID, and the ratio of
+// time the group was enabled/running (needed to scale multiplexed counts).
+const groupReadFormat = unix.PERF_FORMAT_GROUP |
+	unix.PERF_FORMAT_TOTAL_TIME_ENABLED |
+	unix.PERF_FORMAT_TOTAL_TIME_RUNNING |
+	unix.PERF_FORMAT_ID
+
+// NewEventOpener opens attrs[0] as the group leader and attrs[1:] as
+// followers, once per CPU, and stores each CPU's leader fd in array so the
+// eBPF program can look it up by CPU index.
+func NewEventOpener(array *ebpf.Map, opts EventOpenerOptions, attrs ...unix.PerfEventAttr) (*EventOpener, error) {
+	if len(attrs) == 0 {
+		return nil, fmt.Errorf("event_opener: at least one perf_event_attr is required")
+	}
+
 	nCPU := int(array.MaxEntries())
-	eventFDs := make([]int, 0, nCPU)
 
-	// Clone the map to keep a reference
+	// Clone the map to keep a reference independent of the caller's copy.
 	array, err := array.Clone()
 	if err != nil {
 		return nil, err
 	}
 
-	// Create perf events for each CPU
+	cgroupFD := -1
+	if opts.Cgroup != "" {
+		cgroupFD, err = openCgroupPath(opts.Cgroup)
+		if err != nil {
+			array.Close()
+			return nil, err
+		}
+	}
+
+	eo := &EventOpener{
+		array:     array,
+		cgroupFD:  cgroupFD,
+		leaderFDs: make([]int, 0, nCPU),
+		groupFDs:  make([][]int, 0, nCPU),
+		eventIDs:  make([][]uint64, 0, nCPU),
+	}
+
 	for cpu := 0; cpu < nCPU; cpu++ {
-		attr := unix.PerfEventAttr{
-			Type:           unix.PERF_TYPE_HARDWARE,
-			Config:         unix.PERF_COUNT_HW_CPU_CYCLES,
-			Sample:         0,
-			Sample_type:    0,
-			Read_format:    unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING,
-			Bits:          0,
-			Wakeup:        0,
-			Bp_type:       0,
-			Ext1:          0,
-			Ext2:          0,
-		}
-
-		fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, 0)
+		fds, ids, err := openGroup(attrs, opts.Inherit, cgroupFD, cpu)
 		if err != nil {
-			// Clean up already opened FDs
-			for _, fd := range eventFDs {
+			eo.closeLocked()
+			return nil, fmt.Errorf("failed to open perf event group on CPU %d: %v", cpu, err)
+		}
+
+		eo.leaderFDs = append(eo.leaderFDs, fds[0])
+		eo.groupFDs = append(eo.groupFDs, fds)
+		eo.eventIDs = append(eo.eventIDs, ids)
+
+		if err := array.Put(uint32(cpu), uint32(fds[0])); err != nil {
+			eo.closeLocked()
+			return nil, fmt.Errorf("failed to update map for CPU %d: %v", cpu, err)
+		}
+	}
+
+	runtime.SetFinalizer(eo, (*EventOpener).Close)
+	return eo, nil
+}
+
+// openGroup opens attrs[0] as a disabled group leader on the given cpu and
+// attrs[1:] as enabled followers of that leader, then enables the whole
+// group through the leader, returning the fds (leader first) and each
+// event's PERF_FORMAT_ID id. When cgroupFD is >= 0, it is
+// passed as the "pid" argument together with PERF_FLAG_PID_CGROUP, scoping
+// every event to that cgroup instead of to all tasks on cpu.
+func openGroup(attrs []unix.PerfEventAttr, inherit bool, cgroupFD, cpu int) (fds []int, ids []uint64, err error) {
+	defer func() {
+		if err != nil {
+			for _, fd := range fds {
 				unix.Close(fd)
 			}
-			return nil, fmt.Errorf("failed to open perf event on CPU %d: %v", cpu, err)
 		}
+	}()
 
-		eventFDs = append(eventFDs, fd)
+	pid := -1
+	var flags uint
+	if cgroupFD >= 0 {
+		pid = cgroupFD
+		flags = unix.PERF_FLAG_PID_CGROUP
+	}
 
-		// Store FD in map
-		if err := array.Put(uint32(cpu), uint32(fd)); err != nil {
-			// Clean up
-			for _, fd := range eventFDs {
-				unix.Close(fd)
+	leaderAttr := attrs[0]
+	leaderAttr.Size = uint32(unsafe.Sizeof(leaderAttr))
+	leaderAttr.Read_format = groupReadFormat
+	leaderAttr.Sample_type |= unix.PERF_SAMPLE_IDENTIFIER
+	leaderAttr.Disabled = 1
+	if inherit {
+		leaderAttr.Bits |= unix.PerfBitInherit
+	}
+
+	leaderFD, err := unix.PerfEventOpen(&leaderAttr, pid, cpu, -1, flags)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening group leader: %w", err)
+	}
+	fds = append(fds, leaderFD)
+
+	leaderID, err := readEventID(leaderFD)
+	if err != nil {
+		return fds, nil, fmt.Errorf("reading leader event id: %w", err)
+	}
+	ids = append(ids, leaderID)
+
+	for _, followerAttr := range attrs[1:] {
+		followerAttr.Size = uint32(unsafe.Sizeof(followerAttr))
+		followerAttr.Read_format = groupReadFormat
+		followerAttr.Sample_type |= unix.PERF_SAMPLE_IDENTIFIER
+		followerAttr.Disabled = 0
+		if inherit {
+			followerAttr.Bits |= unix.PerfBitInherit
+		}
+
+		fd, err := unix.PerfEventOpen(&followerAttr, pid, cpu, leaderFD, flags)
+		if err != nil {
+			return fds, ids, fmt.Errorf("opening group follower: %w", err)
+		}
+		fds = append(fds, fd)
+
+		id, err := readEventID(fd)
+		if err != nil {
+			return fds, ids, fmt.Errorf("reading follower event id: %w", err)
+		}
+		ids = append(ids, id)
+	}
+
+	// Enable the whole group at once through the leader, now that every
+	// follower is attached to it (1 is PERF_IOC_FLAG_GROUP).
+	if err := unix.IoctlSetInt(leaderFD, unix.PERF_EVENT_IOC_ENABLE, 1); err != nil {
+		return fds, ids, fmt.Errorf("enabling group: %w", err)
+	}
+
+	return fds, ids, nil
+}
+
+// readEventID reads the PERF_EVENT_IOC_ID for fd, which identifies this
+// event's value within a PERF_FORMAT_GROUP read of the leader.
+func readEventID(fd int) (uint64, error) {
+	var id uint64
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.PERF_EVENT_IOC_ID, uintptr(unsafe.Pointer(&id))); errno != 0 {
+		return 0, errno
+	}
+	return id, nil
+}
+
+// GroupRatios is the enabled/running ratio the kernel reports for one CPU's
+// event group, read with PERF_FORMAT_TOTAL_TIME_ENABLED and
+// PERF_FORMAT_TOTAL_TIME_RUNNING. When the kernel multiplexes more event
+// groups onto a CPU than it has PMU slots for, a group may run for only a
+// fraction of the time it was enabled; every counter in the group shares
+// the same ratio, so it can be applied to each one's delta to correct for
+// the time the group spent descheduled.
+type GroupRatios struct {
+	TimeEnabled uint64
+	TimeRunning uint64
+}
+
+// Scale returns the factor a raw delta read from this group should be
+// multiplied by to correct for multiplexing. It is 1 if the group was
+// never descheduled (or the kernel hasn't started running it yet).
+func (g GroupRatios) Scale() float64 {
+	if g.TimeRunning == 0 {
+		return 1
+	}
+	return float64(g.TimeEnabled) / float64(g.TimeRunning)
+}
+
+// ReadRatios reads the leader fd for every CPU with a plain read(2) using
+// the PERF_FORMAT_GROUP layout set up in openGroup, and returns each CPU's
+// enabled/running ratio. This is safe to call concurrently with the eBPF
+// program reading the same fds via bpf_perf_event_read_value: perf event
+// reads are non-destructive snapshots, so nothing here steals or resets
+// the counts the BPF side depends on.
+//
+// time_enabled and time_running are cumulative since the group was
+// opened, not reset per sampling window, so the ratio this produces is a
+// lifetime average rather than the true ratio over whatever window the
+// caller is scaling. This is a userspace-only approximation in place of
+// the kernel-side atomic group read described above.
+//
+// The read(2) layout for PERF_FORMAT_GROUP|TOTAL_TIME_ENABLED|
+// TOTAL_TIME_RUNNING|ID is:
+//
+//	u64 nr;
+//	u64 time_enabled;
+//	u64 time_running;
+//	struct { u64 value; u64 id; } values[nr];
+//
+// Each value's id is checked against the id recorded for that slot when
+// the group was opened, so a kernel returning values in an unexpected
+// order is caught here instead of silently mislabeling a counter.
+func (eo *EventOpener) ReadRatios() ([]GroupRatios, error) {
+	eo.mu.Lock()
+	defer eo.mu.Unlock()
+
+	const headerLen = 24 // nr, time_enabled, time_running
+	const valueLen = 16  // value, id
+
+	ratios := make([]GroupRatios, len(eo.leaderFDs))
+	for cpu, fd := range eo.leaderFDs {
+		nr := len(eo.groupFDs[cpu])
+		buf := make([]byte, headerLen+valueLen*nr)
+		if _, err := unix.Read(fd, buf); err != nil {
+			return nil, fmt.Errorf("reading group on CPU %d: %w", cpu, err)
+		}
+
+		if gotNr := binary.LittleEndian.Uint64(buf[0:8]); int(gotNr) != nr {
+			return nil, fmt.Errorf("reading group on CPU %d: kernel returned %d values, expected %d", cpu, gotNr, nr)
+		}
+
+		for i := 0; i < nr; i++ {
+			off := headerLen + i*valueLen
+			if id := binary.LittleEndian.Uint64(buf[off+8 : off+16]); id != eo.eventIDs[cpu][i] {
+				return nil, fmt.Errorf("reading group on CPU %d: value %d has id %d, expected %d", cpu, i, id, eo.eventIDs[cpu][i])
 			}
-			return nil, fmt.Errorf("failed to update map for CPU %d: %v", cpu, err)
+		}
+
+		ratios[cpu] = GroupRatios{
+			TimeEnabled: binary.LittleEndian.Uint64(buf[8:16]),
+			TimeRunning: binary.LittleEndian.Uint64(buf[16:24]),
 		}
 	}
+	return ratios, nil
+}
 
-	eo := &EventOpener{
-		array:    array,
-		eventFDs: eventFDs,
+// PutFollower stores the follower at attrs[idx+1] (the index among the
+// followers passed to NewEventOpener, 0-based) in array, once per CPU, the
+// same way NewEventOpener stores the leader. This lets a BPF program or any
+// other consumer look up an individual counter's fd by CPU directly,
+// instead of only ever reading the whole group through the leader stored
+// by NewEventOpener.
+func (eo *EventOpener) PutFollower(idx int, array *ebpf.Map) error {
+	eo.mu.Lock()
+	defer eo.mu.Unlock()
+
+	for cpu, fds := range eo.groupFDs {
+		if idx+1 >= len(fds) {
+			return fmt.Errorf("event_opener: follower index %d out of range for a group of %d", idx, len(fds))
+		}
+		if err := array.Put(uint32(cpu), uint32(fds[idx+1])); err != nil {
+			return fmt.Errorf("failed to update map for CPU %d: %v", cpu, err)
+		}
 	}
-	runtime.SetFinalizer(eo, (*EventOpener).Close)
-	return eo, nil
+	return nil
 }
 
-// Close cleans up the event opener resources
+// Close cleans up the event opener resources.
 func (eo *EventOpener) Close() error {
 	eo.mu.Lock()
 	defer eo.mu.Unlock()
+	return eo.closeLocked()
+}
 
-	if eo.eventFDs == nil {
+func (eo *EventOpener) closeLocked() error {
+	if eo.groupFDs == nil {
 		return nil
 	}
 
 	var firstErr error
-	for _, fd := range eo.eventFDs {
-		if err := unix.Close(fd); err != nil && firstErr == nil {
+	for _, fds := range eo.groupFDs {
+		for _, fd := range fds {
+			if err := unix.Close(fd); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	if eo.array != nil {
+		if err := eo.array.Close(); err != nil && firstErr == nil {
 			firstErr = err
 		}
 	}
 
-	if err := eo.array.Close(); err != nil && firstErr == nil {
-		firstErr = err
+	if eo.cgroupFD >= 0 {
+		if err := unix.Close(eo.cgroupFD); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		eo.cgroupFD = -1
 	}
 
-	eo.eventFDs = nil
+	eo.leaderFDs = nil
+	eo.groupFDs = nil
+	eo.eventIDs = nil
 	eo.array = nil
 
 	return firstErr
-} 
\ No newline at end of file
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"time"
@@ -13,9 +16,13 @@ import (
 
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/rlimit"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/unvariance/collector/pkg/aggregate"
+	"github.com/unvariance/collector/pkg/containers"
 	ourperf "github.com/unvariance/collector/pkg/perf"
 	"github.com/unvariance/collector/pkg/perf_ebpf"
+	"github.com/unvariance/collector/pkg/sink"
 	"github.com/xitongsys/parquet-go-source/local"
 	"github.com/xitongsys/parquet-go/parquet"
 	"github.com/xitongsys/parquet-go/source"
@@ -32,23 +39,66 @@ type MetricsRecord struct {
 	Instructions int64 `parquet:"name=instructions, type=INT64"`
 	LLCMisses    int64 `parquet:"name=llc_misses, type=INT64"`
 	Duration     int64 `parquet:"name=duration, type=INT64"`
+	CgroupID     int64 `parquet:"name=cgroup_id, type=INT64"`
+
+	// Container/pod attribution, populated from the containers.Cache when
+	// available. Empty strings mean the rmid hadn't been attributed yet
+	// (e.g. the lookup was still in flight), or the collector was run
+	// without --docker-socket: ContainerID and PodUID only need /proc, but
+	// ContainerName/PodName/PodNamespace require talking to the runtime.
+	ContainerID   string `parquet:"name=container_id, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ContainerName string `parquet:"name=container_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PodName       string `parquet:"name=pod_name, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PodNamespace  string `parquet:"name=pod_namespace, type=BYTE_ARRAY, convertedtype=UTF8"`
+	PodUID        string `parquet:"name=pod_uid, type=BYTE_ARRAY, convertedtype=UTF8"`
+}
+
+// defaultRowGroupBytes is the row group size passed to the parquet writer
+// library itself when no MaxRowGroupBytes is configured.
+const defaultRowGroupBytes = 8 * 1024 * 1024
+
+// countingFile wraps a source.ParquetFile and tallies the bytes written
+// through it, so rotatingWriter can tell when a segment's row group is
+// approaching a configured size cap without needing to understand the
+// Parquet format itself.
+type countingFile struct {
+	source.ParquetFile
+	written int64
+}
+
+func (f *countingFile) Write(p []byte) (int, error) {
+	n, err := f.ParquetFile.Write(p)
+	f.written += int64(n)
+	return n, err
 }
 
 // parquetWriter wraps parquet file writing functionality
 type parquetWriter struct {
-	file   source.ParquetFile
-	writer *writer.ParquetWriter
+	file       *countingFile
+	writer     *writer.ParquetWriter
+	cgroupID   int64 // stamped on every row; 0 when not running cgroup-scoped
+	containers *containers.Cache
 }
 
-// newParquetWriter creates a new parquet writer with the given filename
-func newParquetWriter(filename string) (*parquetWriter, error) {
-	file, err := local.NewLocalFileWriter(filename)
+// newParquetWriter creates a new parquet writer with the given filename.
+// cgroupID is the name_to_handle_at-derived handle of the cgroup the
+// collector is scoped to, or 0 when collecting system-wide. containerCache
+// may be nil, in which case rows carry no container/pod attribution.
+// maxRowGroupBytes, if positive, is also used as the parquet library's own
+// row group buffering threshold; otherwise defaultRowGroupBytes is used.
+func newParquetWriter(filename string, cgroupID int64, containerCache *containers.Cache, maxRowGroupBytes int64) (*parquetWriter, error) {
+	rawFile, err := local.NewLocalFileWriter(filename)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create parquet file: %w", err)
 	}
+	file := &countingFile{ParquetFile: rawFile}
+
+	rowGroupBytes := maxRowGroupBytes
+	if rowGroupBytes <= 0 {
+		rowGroupBytes = defaultRowGroupBytes
+	}
 
-	// Create parquet writer with 8MB row group size and Snappy compression
-	pw, err := writer.NewParquetWriter(file, new(MetricsRecord), 8*1024*1024)
+	pw, err := writer.NewParquetWriter(file, new(MetricsRecord), rowGroupBytes)
 	if err != nil {
 		file.Close()
 		return nil, fmt.Errorf("failed to create parquet writer: %w", err)
@@ -58,11 +108,19 @@ func newParquetWriter(filename string) (*parquetWriter, error) {
 	pw.CompressionType = parquet.CompressionCodec_SNAPPY
 
 	return &parquetWriter{
-		file:   file,
-		writer: pw,
+		file:       file,
+		writer:     pw,
+		cgroupID:   cgroupID,
+		containers: containerCache,
 	}, nil
 }
 
+// bytesWritten returns how many bytes have been written to the underlying
+// file so far, used by rotatingWriter to enforce RotationConfig.MaxRowGroupBytes.
+func (pw *parquetWriter) bytesWritten() int64 {
+	return pw.file.written
+}
+
 // writeTimeSlots writes the completed time slots to the parquet file
 func (pw *parquetWriter) writeTimeSlots(slots []*aggregate.TimeSlot) error {
 	for _, slot := range slots {
@@ -75,7 +133,19 @@ func (pw *parquetWriter) writeTimeSlots(slots []*aggregate.TimeSlot) error {
 				Instructions: int64(agg.Instructions),
 				LLCMisses:    int64(agg.LLCMisses),
 				Duration:     int64(agg.Duration),
+				CgroupID:     pw.cgroupID,
 			}
+
+			if pw.containers != nil {
+				if attribution, ok := pw.containers.Lookup(uint32(rmid)); ok {
+					record.ContainerID = attribution.ContainerID
+					record.ContainerName = attribution.ContainerName
+					record.PodName = attribution.PodName
+					record.PodNamespace = attribution.PodNamespace
+					record.PodUID = attribution.PodUID
+				}
+			}
+
 			if err := pw.writer.Write(record); err != nil {
 				return fmt.Errorf("failed to write record: %w", err)
 			}
@@ -96,12 +166,24 @@ func (pw *parquetWriter) close() error {
 // Note: taskCounterEvent is auto-generated by bpf2go
 // Note: taskCounterRmidMetadata is auto-generated by bpf2go
 
+// scaleCounter applies the enabled/running ratio from EventOpener.ReadRatios
+// to a raw counter delta, correcting for the time its group spent
+// descheduled by the kernel's PMU multiplexing. The ratio is a
+// lifetime-cumulative approximation, not an exact per-sample correction;
+// see the comment on ReadRatios for why.
+func scaleCounter(delta uint64, scale float64) uint64 {
+	if scale <= 1 {
+		return delta
+	}
+	return uint64(float64(delta) * scale)
+}
+
 // nanotime returns monotonic time in nanoseconds. We get this from the runtime
 //
 //go:linkname nanotime runtime.nanotime
 func nanotime() int64
 
-// dumpRmidMap dumps all valid RMIDs and their metadata
+// dumpRmidMap logs all valid RMIDs and their metadata.
 func dumpRmidMap(objs *taskCounterObjects) {
 	var key uint32
 	var metadata taskCounterRmidMetadata
@@ -132,20 +214,147 @@ func dumpRmidMap(objs *taskCounterObjects) {
 	log.Println("") // Add blank line after dump
 }
 
+// reconcileRmidAttribution scans the RMID map and diffs it against known,
+// the set of RMIDs that were valid on the previous call, so containerCache
+// tracks attribution for the whole life of the process instead of just
+// whatever existed when it was first called: an RMID that's valid now but
+// wasn't last time is handed to containerCache as a fresh allocation, and
+// one that was valid last time but has disappeared is freed, so a later
+// reuse of that RMID can't inherit a stale attribution. known is updated in
+// place to the RMIDs seen on this call.
+func reconcileRmidAttribution(objs *taskCounterObjects, containerCache *containers.Cache, known map[uint32]struct{}) {
+	if containerCache == nil {
+		return
+	}
+
+	var key uint32
+	var metadata taskCounterRmidMetadata
+	seen := make(map[uint32]struct{}, len(known))
+
+	for i := uint32(0); i < 512; i++ { // max_entries is 512 from task_counter.c
+		key = i
+		if err := objs.RmidMap.Lookup(&key, &metadata); err != nil {
+			continue
+		}
+		if metadata.Valid != 1 {
+			continue
+		}
+
+		seen[key] = struct{}{}
+		if _, ok := known[key]; !ok {
+			containerCache.OnRMIDAlloc(key, metadata.Tgid)
+		}
+	}
+
+	for rmid := range known {
+		if _, ok := seen[rmid]; !ok {
+			containerCache.OnRMIDFree(rmid)
+		}
+	}
+
+	for rmid := range known {
+		delete(known, rmid)
+	}
+	for rmid := range seen {
+		known[rmid] = struct{}{}
+	}
+}
+
 func main() {
+	cgroupPath := flag.String("cgroup", "", "scope perf event collection to this cgroup v2 directory instead of the whole system")
+	outputDir := flag.String("output-dir", "metrics", "directory to write rotating metrics-<start>-<end>.parquet segments and the manifest into")
+	segmentDuration := flag.Duration("segment-duration", 10*time.Second, "wall-clock span covered by each Parquet segment before it is rotated")
+	maxSegmentBytes := flag.Int64("max-segment-bytes", 0, "rotate a segment early once it has this many bytes written, in addition to --segment-duration; 0 disables the size-based check")
+	retention := flag.Duration("retention", 10*time.Minute, "how long closed segments are kept before being deleted; 0 disables retention")
+	otlpEndpoint := flag.String("otlp-endpoint", "", "if set, also export completed time slots as OTLP metrics to this gRPC endpoint")
+	promRemoteWriteURL := flag.String("prom-remote-write-url", "", "if set, also export completed time slots via Prometheus remote write to this URL")
+	tcpSinkAddr := flag.String("tcp-sink-addr", "", "if set, also export completed time slots as carbon-style lines to this host:port over TCP")
+	sinkWriteTimeout := flag.Duration("sink-write-timeout", 5*time.Second, "write timeout for the TCP sink's underlying connection and the Prometheus remote-write sink's HTTP POSTs")
+	metricsAddr := flag.String("metrics-addr", ":9464", "address to serve Prometheus /metrics (sink dropped-batches counters) on; empty disables it")
+	dockerSocket := flag.String("docker-socket", "", "if set, resolve container_name/pod_name/pod_namespace by querying the Docker (or containerd CRI-compatible) engine API at this unix socket path")
+	flag.Parse()
+
 	// Allow the current process to lock memory for eBPF resources
 	if err := rlimit.RemoveMemlock(); err != nil {
 		log.Fatal(err)
 	}
 
-	// Create parquet writer
-	pw, err := newParquetWriter("metrics.parquet")
+	var cgroupIDValue int64
+	if *cgroupPath != "" {
+		id, err := cgroupID(*cgroupPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cgroupIDValue = int64(id)
+	}
+
+	// ProcResolver alone can only recover container_id/pod_uid from
+	// /proc/<tgid>/cgroup; container_name/pod_name/pod_namespace need
+	// --docker-socket so ComposedResolver can ask the runtime for them.
+	var attributionResolver containers.Resolver = containers.NewProcResolver()
+	if *dockerSocket != "" {
+		attributionResolver = containers.NewComposedResolver(attributionResolver, containers.NewDockerResolver(*dockerSocket))
+	}
+	containerCache := containers.NewCache(attributionResolver)
+	defer containerCache.Close()
+
+	// Create the rotating parquet writer
+	rotationCfg := RotationConfig{
+		Dir:              *outputDir,
+		SegmentDuration:  *segmentDuration,
+		MaxRowGroupBytes: *maxSegmentBytes,
+		Retention:        *retention,
+	}
+	rw, err := newRotatingWriter(rotationCfg, cgroupIDValue, containerCache)
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	// Every sink's dropped-batches counter is registered here so it's
+	// actually observable on the /metrics endpoint started below, instead
+	// of just accumulating in memory.
+	metricsRegistry := prometheus.NewRegistry()
+	newBufferedSink := func(name string, underlying sink.Sink) *sink.BufferedSink {
+		bs := sink.NewBufferedSink(name, underlying)
+		metricsRegistry.MustRegister(bs.Collector())
+		return bs
+	}
+
+	// Parquet is always one of the sinks; OTLP/Prometheus/TCP are opt-in via
+	// flags. Every sink is wrapped in a BufferedSink so a slow or stalled
+	// downstream can never back up the perf event loop that feeds it.
+	sinks := sink.Fanout{newBufferedSink("parquet", &parquetSink{rw: rw})}
+
+	if *otlpEndpoint != "" {
+		otlpSink, err := sink.NewOTLPSink(context.Background(), *otlpEndpoint)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sinks = append(sinks, newBufferedSink("otlp", otlpSink))
+	}
+
+	if *promRemoteWriteURL != "" {
+		sinks = append(sinks, newBufferedSink("prometheus_remote_write", sink.NewPromRemoteWriteSink(*promRemoteWriteURL, *sinkWriteTimeout, nil)))
+	}
+
+	if *tcpSinkAddr != "" {
+		sinks = append(sinks, newBufferedSink("tcp", sink.NewTCPSink(*tcpSinkAddr, *sinkWriteTimeout)))
+	}
+
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				log.Printf("metrics server on %s: %v", *metricsAddr, err)
+			}
+		}()
+	}
+
+	var pw sink.Sink = sinks
 	defer func() {
-		if err := pw.close(); err != nil {
-			log.Printf("Error closing parquet writer: %v", err)
+		if err := pw.Close(); err != nil {
+			log.Printf("Error closing sinks: %v", err)
 		}
 	}()
 
@@ -197,47 +406,50 @@ func main() {
 	}
 	defer rd.Close()
 
-	// Create the event openers for hardware counters
+	// Open cycles, instructions, and LLC misses as one PERF_FORMAT_GROUP
+	// group per CPU, with cycles as the leader. Reading the leader's fd
+	// then returns all three counters from the same atomic snapshot, so
+	// they share one enabled/running ratio instead of drifting relative
+	// to each other when the kernel multiplexes them.
 	commonOpts := unix.PerfEventAttr{
-		Sample:      0,
-		Sample_type: 0,
-		Read_format: unix.PERF_FORMAT_TOTAL_TIME_ENABLED | unix.PERF_FORMAT_TOTAL_TIME_RUNNING,
-		Bits:        0,
-		Wakeup:      0,
-		Bp_type:     0,
-		Ext1:        0,
-		Ext2:        0,
+		Type:   unix.PERF_TYPE_HARDWARE,
+		Sample: 0,
+		Bits:   0,
+		Wakeup: 0,
 	}
 
-	// Open cycles counter
 	cyclesAttr := commonOpts
-	cyclesAttr.Type = unix.PERF_TYPE_HARDWARE
 	cyclesAttr.Config = unix.PERF_COUNT_HW_CPU_CYCLES
-	cyclesOpener, err := NewEventOpener(objs.Cycles, cyclesAttr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer cyclesOpener.Close()
 
-	// Open instructions counter
 	instrAttr := commonOpts
-	instrAttr.Type = unix.PERF_TYPE_HARDWARE
 	instrAttr.Config = unix.PERF_COUNT_HW_INSTRUCTIONS
-	instrOpener, err := NewEventOpener(objs.Instructions, instrAttr)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer instrOpener.Close()
 
-	// Open LLC misses counter
 	llcAttr := commonOpts
-	llcAttr.Type = unix.PERF_TYPE_HARDWARE
 	llcAttr.Config = unix.PERF_COUNT_HW_CACHE_MISSES
-	llcOpener, err := NewEventOpener(objs.LlcMisses, llcAttr)
+
+	eventOpenerOpts := EventOpenerOptions{
+		Cgroup: *cgroupPath,
+		// When scoped to a cgroup, follow forked children automatically so
+		// short-lived children of long-running pod processes aren't missed.
+		Inherit: *cgroupPath != "",
+	}
+
+	counterOpener, err := NewEventOpener(objs.Cycles, eventOpenerOpts, cyclesAttr, instrAttr, llcAttr)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer llcOpener.Close()
+	defer counterOpener.Close()
+
+	// Also store the instructions/LLC-misses follower fds in their own
+	// per-CPU maps, so a BPF program can still look an individual counter
+	// up directly instead of only reading the whole group through the
+	// cycles leader.
+	if err := counterOpener.PutFollower(0, objs.Instructions); err != nil {
+		log.Fatal(err)
+	}
+	if err := counterOpener.PutFollower(1, objs.LlcMisses); err != nil {
+		log.Fatal(err)
+	}
 
 	// Trigger RMID dump via procfs
 	if err := ioutil.WriteFile("/proc/unvariance_collector", []byte("dump"), 0644); err != nil {
@@ -251,8 +463,12 @@ func main() {
 	}
 	log.Println("Closed RMID existing tracepoint")
 
-	// Dump RMID map after initial dump
+	// Dump RMID map after initial dump, and seed containerCache with
+	// whatever RMIDs already exist so reconcileRmidAttribution only has to
+	// report the difference from here on.
 	dumpRmidMap(&objs)
+	rmidAttribution := make(map[uint32]struct{})
+	reconcileRmidAttribution(&objs, containerCache, rmidAttribution)
 
 	// Catch CTRL+C
 	stopper := make(chan os.Signal, 1)
@@ -283,8 +499,8 @@ func main() {
 
 	// Helper function to write completed time slots to parquet
 	writeCompletedSlots := func(slots []*aggregate.TimeSlot) {
-		if err := pw.writeTimeSlots(slots); err != nil {
-			log.Printf("Error writing time slots to parquet: %v", err)
+		if err := pw.WriteSlots(slots); err != nil {
+			log.Printf("Error writing time slots to sinks: %v", err)
 		}
 	}
 
@@ -312,6 +528,15 @@ func main() {
 				log.Fatal(err)
 			}
 
+			// Read each CPU's current enabled/running ratio once per batch
+			// so every event's delta in this batch is scaled by the same
+			// snapshot, rather than drifting as the ratio changes mid-batch.
+			ratios, err := counterOpener.ReadRatios()
+			if err != nil {
+				log.Printf("Error reading group ratios: %s", err)
+				ratios = nil
+			}
+
 			// Process all available events that occurred before startTimestamp
 			for !reader.Empty() {
 				// Check if next event's timestamp is after our start timestamp
@@ -363,12 +588,21 @@ func main() {
 					break
 				}
 
+				// Scale this event's deltas by the ratio its CPU's group
+				// was actually running, so a counter multiplexed off the
+				// PMU for part of the interval doesn't read artificially
+				// low relative to the others in its group.
+				scale := 1.0
+				if int(cpuID) < len(ratios) {
+					scale = ratios[cpuID].Scale()
+				}
+
 				// Create measurement from event
 				measurement := &aggregate.Measurement{
 					RMID:         event.Rmid,
-					Cycles:       event.CyclesDelta,
-					Instructions: event.InstructionsDelta,
-					LLCMisses:    event.LlcMissesDelta,
+					Cycles:       scaleCounter(event.CyclesDelta, scale),
+					Instructions: scaleCounter(event.InstructionsDelta, scale),
+					LLCMisses:    scaleCounter(event.LlcMissesDelta, scale),
 					Timestamp:    event.Timestamp,
 					Duration:     event.TimeDeltaNs,
 				}
@@ -398,6 +632,12 @@ func main() {
 				log.Fatal(err)
 			}
 			log.Printf("Event count: userspace %d, eBPF %d\n", totalEvents, count)
+
+			// Refresh RMID -> container/pod attribution for the whole
+			// life of the process, not just at startup/shutdown, so a
+			// container that appears mid-run isn't left unattributed and
+			// a freed RMID doesn't keep serving a stale attribution.
+			reconcileRmidAttribution(&objs, containerCache, rmidAttribution)
 		}
 	}
 }
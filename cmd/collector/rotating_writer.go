@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+	"github.com/unvariance/collector/pkg/containers"
+)
+
+// RotationConfig controls how the collector splits its Parquet output into
+// a directory of bounded segments instead of one ever-growing file.
+type RotationConfig struct {
+	// Dir is the directory segments and the manifest are written to. It is
+	// created if it does not already exist.
+	Dir string
+	// SegmentDuration is the wall-clock span each segment covers. Rotation
+	// only happens on an aggregator slot boundary, so no time slot is ever
+	// split across two segments.
+	SegmentDuration time.Duration
+	// MaxRowGroupBytes, if positive, rotates the current segment early
+	// once the bytes written to it (tracked by parquetWriter.bytesWritten,
+	// which counts only what the parquet library has already flushed to the
+	// file) reach this size, in addition to the SegmentDuration-based
+	// rotation. It is also used as the parquet writer's own row group
+	// buffering threshold. Zero means segments are only rotated by
+	// SegmentDuration.
+	MaxRowGroupBytes int64
+	// Retention is how long a closed segment is kept on disk before the
+	// retention goroutine removes it. Zero disables retention.
+	Retention time.Duration
+}
+
+// segmentInfo is one line of the manifest, and one entry in the in-memory
+// index used by Query.
+type segmentInfo struct {
+	Path    string `json:"path"`
+	StartNs int64  `json:"start_ns"`
+	EndNs   int64  `json:"end_ns"`
+}
+
+// rotatingWriter fans completed time slots into a sequence of
+// metrics-<start_ns>-<end_ns>.parquet segments, rotating on aggregator slot
+// boundaries, and prunes segments older than its retention window. Segment
+// finalization (WriteStop, fsync, rename) runs on a background goroutine so
+// it never stalls the caller, which is expected to be the hot perf-event
+// loop.
+type rotatingWriter struct {
+	cfg            RotationConfig
+	cgroupID       int64
+	containerCache *containers.Cache
+
+	mu           sync.Mutex
+	manifest     []segmentInfo
+	manifestPath string // final manifest path; written to manifestPath+".tmp" then renamed into place
+
+	current      *parquetWriter
+	currentPath  string
+	segmentStart int64 // ns, start of the current segment
+	segmentEnd   int64 // ns, end of the last slot written to the current segment
+
+	closing sync.WaitGroup // outstanding async segment finalizations
+
+	stopRetention chan struct{}
+}
+
+// newRotatingWriter creates the output directory (if absent) and starts the
+// retention loop. The manifest is written once the first segment is
+// finalized. The first segment is opened lazily, on the
+// first call to WriteTimeSlots, once we know the first slot's start time.
+func newRotatingWriter(cfg RotationConfig, cgroupID int64, containerCache *containers.Cache) (*rotatingWriter, error) {
+	if cfg.SegmentDuration <= 0 {
+		return nil, fmt.Errorf("rotating_writer: SegmentDuration must be positive")
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating output directory: %w", err)
+	}
+
+	rw := &rotatingWriter{
+		cfg:            cfg,
+		cgroupID:       cgroupID,
+		containerCache: containerCache,
+		manifestPath:   filepath.Join(cfg.Dir, "manifest.json"),
+		stopRetention:  make(chan struct{}),
+	}
+
+	if cfg.Retention > 0 {
+		go rw.retentionLoop()
+	}
+
+	return rw, nil
+}
+
+// WriteTimeSlots writes slots to the current segment, opening one if none is
+// open, and rotates right after any slot whose end brings the segment to
+// SegmentDuration (or, if set, its bytes written to MaxRowGroupBytes).
+func (rw *rotatingWriter) WriteTimeSlots(slots []*aggregate.TimeSlot) error {
+	for _, slot := range slots {
+		if err := rw.ensureSegment(int64(slot.StartTime)); err != nil {
+			return err
+		}
+
+		if err := rw.current.writeTimeSlots([]*aggregate.TimeSlot{slot}); err != nil {
+			return err
+		}
+		rw.segmentEnd = int64(slot.EndTime)
+
+		rotateNow := int64(slot.EndTime)-rw.segmentStart >= rw.cfg.SegmentDuration.Nanoseconds()
+		if !rotateNow && rw.cfg.MaxRowGroupBytes > 0 {
+			rotateNow = rw.current.bytesWritten() >= rw.cfg.MaxRowGroupBytes
+		}
+		if rotateNow {
+			if err := rw.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureSegment opens a new segment starting at startNs if none is open.
+func (rw *rotatingWriter) ensureSegment(startNs int64) error {
+	if rw.current != nil {
+		return nil
+	}
+
+	path := filepath.Join(rw.cfg.Dir, fmt.Sprintf("metrics-%d-inprogress.parquet", startNs))
+	pw, err := newParquetWriter(path, rw.cgroupID, rw.containerCache, rw.cfg.MaxRowGroupBytes)
+	if err != nil {
+		return fmt.Errorf("opening segment %q: %w", path, err)
+	}
+
+	rw.current = pw
+	rw.currentPath = path
+	rw.segmentStart = startNs
+	rw.segmentEnd = startNs
+	return nil
+}
+
+// rotate hands the current segment off to a goroutine to close
+// (WriteStop/fsync/rename can take a while) and clears state so the next
+// WriteTimeSlots call opens a fresh one.
+func (rw *rotatingWriter) rotate() error {
+	pw := rw.current
+	oldPath := rw.currentPath
+	finalPath := filepath.Join(rw.cfg.Dir, fmt.Sprintf("metrics-%d-%d.parquet", rw.segmentStart, rw.segmentEnd))
+	info := segmentInfo{Path: finalPath, StartNs: rw.segmentStart, EndNs: rw.segmentEnd}
+
+	rw.current = nil
+	rw.currentPath = ""
+
+	rw.closing.Add(1)
+	go func() {
+		defer rw.closing.Done()
+		if err := pw.close(); err != nil {
+			log.Printf("Error closing segment %q: %v", oldPath, err)
+			return
+		}
+		f, err := os.Open(oldPath)
+		if err != nil {
+			log.Printf("Error opening segment %q for fsync: %v", oldPath, err)
+			return
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			log.Printf("Error syncing segment %q: %v", oldPath, err)
+			return
+		}
+		if err := os.Rename(oldPath, finalPath); err != nil {
+			log.Printf("Error renaming segment %q -> %q: %v", oldPath, finalPath, err)
+			return
+		}
+		rw.addToManifest(info)
+	}()
+
+	return nil
+}
+
+// addToManifest appends info to the manifest and persists it to disk.
+func (rw *rotatingWriter) addToManifest(info segmentInfo) {
+	rw.mu.Lock()
+	rw.manifest = append(rw.manifest, info)
+	manifest := append([]segmentInfo(nil), rw.manifest...)
+	rw.mu.Unlock()
+
+	rw.writeManifest(manifest)
+}
+
+// writeManifest persists manifest to disk atomically: it's marshaled to a
+// temp file next to manifestPath, then renamed over manifestPath, so a
+// crash mid-write can never leave the only index of all segments
+// truncated or corrupted.
+func (rw *rotatingWriter) writeManifest(manifest []segmentInfo) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		log.Printf("Error marshaling manifest: %v", err)
+		return
+	}
+
+	tmp := rw.manifestPath + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		log.Printf("Error writing manifest: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, rw.manifestPath); err != nil {
+		log.Printf("Error renaming manifest into place: %v", err)
+	}
+}
+
+// Query returns the paths of closed segments overlapping the last d of
+// wall-clock time, oldest first. It mirrors the way internal/traceparser
+// lets cmd/trace read trailing segments of a trace without loading the
+// whole thing.
+func (rw *rotatingWriter) Query(d time.Duration) []string {
+	cutoff := int64(nanotime()) - d.Nanoseconds()
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	var paths []string
+	for _, seg := range rw.manifest {
+		if seg.EndNs >= cutoff {
+			paths = append(paths, seg.Path)
+		}
+	}
+	return paths
+}
+
+// retentionLoop deletes closed segments older than cfg.Retention.
+func (rw *rotatingWriter) retentionLoop() {
+	ticker := time.NewTicker(rw.cfg.Retention / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rw.pruneOldSegments()
+		case <-rw.stopRetention:
+			return
+		}
+	}
+}
+
+func (rw *rotatingWriter) pruneOldSegments() {
+	cutoff := int64(nanotime()) - rw.cfg.Retention.Nanoseconds()
+
+	rw.mu.Lock()
+	kept := rw.manifest[:0]
+	var removed []segmentInfo
+	for _, seg := range rw.manifest {
+		if seg.EndNs < cutoff {
+			removed = append(removed, seg)
+			continue
+		}
+		kept = append(kept, seg)
+	}
+	rw.manifest = kept
+	manifest := append([]segmentInfo(nil), rw.manifest...)
+	rw.mu.Unlock()
+
+	for _, seg := range removed {
+		if err := os.Remove(seg.Path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Error removing expired segment %q: %v", seg.Path, err)
+		}
+	}
+
+	if len(removed) > 0 {
+		rw.writeManifest(manifest)
+	}
+}
+
+// Close rotates out any open segment, waits for all outstanding
+// finalizations, and stops the retention loop.
+func (rw *rotatingWriter) Close() error {
+	var err error
+	if rw.current != nil {
+		err = rw.rotate()
+	}
+	if rw.cfg.Retention > 0 {
+		close(rw.stopRetention)
+	}
+	rw.closing.Wait()
+	return err
+}
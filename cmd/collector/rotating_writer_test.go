@@ -0,0 +1,123 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+func makeSlot(startNs, endNs int64, rmid uint32) *aggregate.TimeSlot {
+	return &aggregate.TimeSlot{
+		StartTime: uint64(startNs),
+		EndTime:   uint64(endNs),
+		Aggregations: map[uint32]aggregate.Aggregation{
+			rmid: {
+				Cycles:       1000,
+				Instructions: 2000,
+				LLCMisses:    3,
+				Duration:     uint64(endNs - startNs),
+			},
+		},
+	}
+}
+
+// allTime makes Query return every finalized segment: the test slots end a
+// few ns after boot, which can be far more than an hour before now.
+const allTime = time.Duration(math.MaxInt64)
+
+func waitForSegments(t *testing.T, rw *rotatingWriter, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if paths := rw.Query(allTime); len(paths) == n {
+			return paths
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d finalized segment(s)", n)
+	return nil
+}
+
+func TestRotatingWriterRotatesOnSegmentDuration(t *testing.T) {
+	dir := t.TempDir()
+	cfg := RotationConfig{Dir: dir, SegmentDuration: 100 * time.Millisecond}
+	rw, err := newRotatingWriter(cfg, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	if err := rw.WriteTimeSlots([]*aggregate.TimeSlot{makeSlot(0, 50_000_000, 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if rw.current == nil {
+		t.Fatal("expected a segment to be open after the first write")
+	}
+
+	// This slot ends at >= SegmentDuration after segmentStart, so it should
+	// trigger a rotation.
+	if err := rw.WriteTimeSlots([]*aggregate.TimeSlot{makeSlot(50_000_000, 150_000_000, 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := waitForSegments(t, rw, 1)
+	if _, err := os.Stat(paths[0]); err != nil {
+		t.Fatalf("expected finalized segment file to exist: %v", err)
+	}
+}
+
+func TestRotatingWriterRotatesOnMaxRowGroupBytes(t *testing.T) {
+	dir := t.TempDir()
+	// SegmentDuration is large enough that only the byte cap can explain a
+	// rotation here.
+	cfg := RotationConfig{Dir: dir, SegmentDuration: time.Hour, MaxRowGroupBytes: 1}
+	rw, err := newRotatingWriter(cfg, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	for i := 0; i < 10; i++ {
+		start := int64(i) * 10_000_000
+		if err := rw.WriteTimeSlots([]*aggregate.TimeSlot{makeSlot(start, start+10_000_000, uint32(i))}); err != nil {
+			t.Fatal(err)
+		}
+		if rw.current == nil {
+			// Rotated early because of MaxRowGroupBytes, as expected.
+			return
+		}
+	}
+
+	t.Fatal("expected MaxRowGroupBytes to force a rotation well before SegmentDuration elapsed")
+}
+
+func TestRotatingWriterPrunesOldSegments(t *testing.T) {
+	dir := t.TempDir()
+	cfg := RotationConfig{Dir: dir, SegmentDuration: time.Nanosecond, Retention: 200 * time.Millisecond}
+	rw, err := newRotatingWriter(cfg, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.Close()
+
+	if err := rw.WriteTimeSlots([]*aggregate.TimeSlot{makeSlot(0, 1, 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForSegments(t, rw, 1)
+
+	// Retention is tiny relative to how old this segment's EndNs (1ns since
+	// boot) will look next to the real monotonic clock, so the next
+	// retentionLoop tick (every Retention/4) should prune it.
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if len(rw.Query(allTime)) == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("expected retention to have pruned the segment")
+}
@@ -0,0 +1,19 @@
+package main
+
+import "github.com/unvariance/collector/pkg/aggregate"
+
+// parquetSink adapts rotatingWriter to the sink.Sink interface so the local
+// Parquet output can be composed with other sinks via sink.Fanout.
+type parquetSink struct {
+	rw *rotatingWriter
+}
+
+// WriteSlots implements sink.Sink.
+func (s *parquetSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	return s.rw.WriteTimeSlots(slots)
+}
+
+// Close implements sink.Sink.
+func (s *parquetSink) Close() error {
+	return s.rw.Close()
+}
@@ -0,0 +1,90 @@
+package containers
+
+import "sync"
+
+// allocRequestQueueLen bounds how many outstanding RMID-alloc lookups the
+// cache will queue. It's sized generously relative to the RMID space (512
+// entries in the eBPF map as of this writing) since allocations are rare
+// compared to samples.
+const allocRequestQueueLen = 512
+
+// Cache maps RMID -> container/pod Attribution. A Resolver lookup can mean
+// a /proc read or a round trip to a container runtime, so resolution
+// happens on a background worker and RMID-alloc events just enqueue a
+// request for it.
+type Cache struct {
+	resolver Resolver
+
+	mu     sync.RWMutex
+	byRMID map[uint32]Attribution
+
+	allocs chan allocRequest
+	done   chan struct{}
+}
+
+type allocRequest struct {
+	rmid uint32
+	tgid uint32
+}
+
+// NewCache starts a Cache backed by resolver. Call Close when done to stop
+// the background worker.
+func NewCache(resolver Resolver) *Cache {
+	c := &Cache{
+		resolver: resolver,
+		byRMID:   make(map[uint32]Attribution),
+		allocs:   make(chan allocRequest, allocRequestQueueLen),
+		done:     make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// OnRMIDAlloc records that rmid was just assigned to tgid. If the queue is
+// full, the request is dropped silently and that RMID is left unattributed
+// until it's reused; a burst of allocations should lose some attributions
+// rather than apply backpressure to the caller.
+func (c *Cache) OnRMIDAlloc(rmid, tgid uint32) {
+	select {
+	case c.allocs <- allocRequest{rmid: rmid, tgid: tgid}:
+	default:
+	}
+}
+
+// OnRMIDFree drops any cached attribution for rmid so it doesn't leak onto
+// whatever task the RMID is handed to next.
+func (c *Cache) OnRMIDFree(rmid uint32) {
+	c.mu.Lock()
+	delete(c.byRMID, rmid)
+	c.mu.Unlock()
+}
+
+// Lookup returns the attribution cached for rmid, if any resolved yet.
+func (c *Cache) Lookup(rmid uint32) (Attribution, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	attribution, ok := c.byRMID[rmid]
+	return attribution, ok
+}
+
+// Close stops the background worker. Outstanding requests are dropped.
+func (c *Cache) Close() {
+	close(c.done)
+}
+
+func (c *Cache) run() {
+	for {
+		select {
+		case req := <-c.allocs:
+			attribution, ok := c.resolver.Resolve(req.tgid)
+			if !ok {
+				continue
+			}
+			c.mu.Lock()
+			c.byRMID[req.rmid] = attribution
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
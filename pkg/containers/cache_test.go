@@ -0,0 +1,95 @@
+package containers
+
+import (
+	"testing"
+	"time"
+)
+
+func waitForLookup(t *testing.T, c *Cache, rmid uint32) (Attribution, bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if attribution, ok := c.Lookup(rmid); ok {
+			return attribution, ok
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return Attribution{}, false
+}
+
+func TestCacheOnRMIDAllocResolvesAsynchronously(t *testing.T) {
+	resolver := NewFakeResolver()
+	resolver.Set(42, Attribution{ContainerID: "abc123", PodName: "my-pod"})
+
+	c := NewCache(resolver)
+	defer c.Close()
+
+	c.OnRMIDAlloc(7, 42)
+
+	attribution, ok := waitForLookup(t, c, 7)
+	if !ok {
+		t.Fatal("expected rmid 7 to be attributed")
+	}
+	if attribution.ContainerID != "abc123" || attribution.PodName != "my-pod" {
+		t.Fatalf("unexpected attribution: %+v", attribution)
+	}
+}
+
+func TestCacheOnRMIDAllocUnresolvedTgidStaysUnattributed(t *testing.T) {
+	resolver := NewFakeResolver()
+	c := NewCache(resolver)
+	defer c.Close()
+
+	c.OnRMIDAlloc(7, 99) // tgid 99 was never Set, so Resolve returns ok=false
+
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := c.Lookup(7); ok {
+		t.Fatal("expected rmid 7 to remain unattributed")
+	}
+}
+
+func TestCacheOnRMIDFreeDropsAttribution(t *testing.T) {
+	resolver := NewFakeResolver()
+	resolver.Set(42, Attribution{ContainerID: "abc123"})
+
+	c := NewCache(resolver)
+	defer c.Close()
+
+	c.OnRMIDAlloc(7, 42)
+	if _, ok := waitForLookup(t, c, 7); !ok {
+		t.Fatal("expected rmid 7 to be attributed before freeing it")
+	}
+
+	c.OnRMIDFree(7)
+
+	if _, ok := c.Lookup(7); ok {
+		t.Fatal("expected rmid 7 to be unattributed after OnRMIDFree")
+	}
+}
+
+func TestCacheOnRMIDAllocOverwritesPriorAttribution(t *testing.T) {
+	resolver := NewFakeResolver()
+	resolver.Set(1, Attribution{ContainerID: "first"})
+	resolver.Set(2, Attribution{ContainerID: "second"})
+
+	c := NewCache(resolver)
+	defer c.Close()
+
+	c.OnRMIDAlloc(7, 1)
+	if attribution, ok := waitForLookup(t, c, 7); !ok || attribution.ContainerID != "first" {
+		t.Fatalf("expected rmid 7 attributed to %q, got %+v (ok=%v)", "first", attribution, ok)
+	}
+
+	// Simulate the RMID being freed and reused by a different tgid.
+	c.OnRMIDFree(7)
+	c.OnRMIDAlloc(7, 2)
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if attribution, ok := c.Lookup(7); ok && attribution.ContainerID == "second" {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("expected rmid 7 to end up attributed to \"second\"")
+}
@@ -0,0 +1,85 @@
+package containers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dockerPodNameLabel, dockerPodNamespaceLabel, and dockerContainerNameLabel
+// are the labels the Docker and containerd CRI plugins both set on every
+// container Kubernetes creates, so a single client works against either
+// runtime without needing the full CRI gRPC API.
+const (
+	dockerPodNameLabel       = "io.kubernetes.pod.name"
+	dockerPodNamespaceLabel  = "io.kubernetes.pod.namespace"
+	dockerContainerNameLabel = "io.kubernetes.container.name"
+)
+
+// DockerResolver is a RuntimeResolver backed by the Docker engine API's
+// "inspect container" endpoint, reached over a unix socket.
+type DockerResolver struct {
+	client *http.Client
+}
+
+// NewDockerResolver returns a RuntimeResolver that talks to the Docker (or
+// containerd, which speaks the same API on the same socket when the
+// docker-shim compatibility endpoint is enabled) engine API over
+// socketPath, e.g. "/var/run/docker.sock".
+func NewDockerResolver(socketPath string) *DockerResolver {
+	return &DockerResolver{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+			Timeout: 2 * time.Second,
+		},
+	}
+}
+
+// dockerInspect is the subset of `GET /containers/{id}/json` we need.
+type dockerInspect struct {
+	Name   string `json:"Name"`
+	Config struct {
+		Labels map[string]string `json:"Labels"`
+	} `json:"Config"`
+}
+
+// ResolveContainer implements RuntimeResolver.
+func (r *DockerResolver) ResolveContainer(containerID string) (Attribution, bool) {
+	req, err := http.NewRequest(http.MethodGet, "http://unix/containers/"+containerID+"/json", nil)
+	if err != nil {
+		return Attribution{}, false
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Attribution{}, false
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Attribution{}, false
+	}
+
+	var inspect dockerInspect
+	if err := json.NewDecoder(resp.Body).Decode(&inspect); err != nil {
+		return Attribution{}, false
+	}
+
+	attribution := Attribution{
+		ContainerName: strings.TrimPrefix(inspect.Name, "/"),
+		PodName:       inspect.Config.Labels[dockerPodNameLabel],
+		PodNamespace:  inspect.Config.Labels[dockerPodNamespaceLabel],
+	}
+	if name := inspect.Config.Labels[dockerContainerNameLabel]; name != "" {
+		attribution.ContainerName = name
+	}
+	return attribution, true
+}
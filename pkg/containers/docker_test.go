@@ -0,0 +1,87 @@
+package containers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// newDockerTestServer starts an httptest.Server listening on a unix socket
+// in t.TempDir() instead of a TCP port, so DockerResolver's unix-socket
+// dialer can be exercised without touching the real Docker daemon.
+func newDockerTestServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, string) {
+	t.Helper()
+
+	socketPath := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(handler)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return server, socketPath
+}
+
+func TestDockerResolverResolveContainer(t *testing.T) {
+	_, socketPath := newDockerTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/containers/abc123/json" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"Name": "/k8s_web_my-pod_default_abc123_0",
+			"Config": map[string]any{
+				"Labels": map[string]string{
+					dockerPodNameLabel:       "my-pod",
+					dockerPodNamespaceLabel:  "default",
+					dockerContainerNameLabel: "web",
+				},
+			},
+		})
+	})
+
+	resolver := NewDockerResolver(socketPath)
+	attribution, ok := resolver.ResolveContainer("abc123")
+	if !ok {
+		t.Fatal("expected ResolveContainer to succeed")
+	}
+	if attribution.ContainerName != "web" || attribution.PodName != "my-pod" || attribution.PodNamespace != "default" {
+		t.Fatalf("unexpected attribution: %+v", attribution)
+	}
+}
+
+func TestDockerResolverFallsBackToBareNameWithoutK8sLabels(t *testing.T) {
+	_, socketPath := newDockerTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"Name":   "/my-container",
+			"Config": map[string]any{"Labels": map[string]string{}},
+		})
+	})
+
+	resolver := NewDockerResolver(socketPath)
+	attribution, ok := resolver.ResolveContainer("abc123")
+	if !ok {
+		t.Fatal("expected ResolveContainer to succeed")
+	}
+	if attribution.ContainerName != "my-container" || attribution.PodName != "" {
+		t.Fatalf("unexpected attribution: %+v", attribution)
+	}
+}
+
+func TestDockerResolverUnknownContainer(t *testing.T) {
+	_, socketPath := newDockerTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such container", http.StatusNotFound)
+	})
+
+	resolver := NewDockerResolver(socketPath)
+	if _, ok := resolver.ResolveContainer("missing"); ok {
+		t.Fatal("expected ResolveContainer to report ok=false for an unknown container")
+	}
+}
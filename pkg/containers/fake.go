@@ -0,0 +1,30 @@
+package containers
+
+import "sync"
+
+// FakeResolver is a Resolver backed by a plain map, for tests that need
+// deterministic attribution without a real container runtime or /proc.
+type FakeResolver struct {
+	mu           sync.RWMutex
+	attributions map[uint32]Attribution
+}
+
+// NewFakeResolver returns an empty FakeResolver.
+func NewFakeResolver() *FakeResolver {
+	return &FakeResolver{attributions: make(map[uint32]Attribution)}
+}
+
+// Set registers the attribution to return for tgid.
+func (r *FakeResolver) Set(tgid uint32, attribution Attribution) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attributions[tgid] = attribution
+}
+
+// Resolve implements Resolver.
+func (r *FakeResolver) Resolve(tgid uint32) (Attribution, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	attribution, ok := r.attributions[tgid]
+	return attribution, ok
+}
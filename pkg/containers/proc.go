@@ -0,0 +1,80 @@
+package containers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// containerIDPattern matches the container ID embedded in a cgroup v1/v2
+// path by the common runtimes (docker, containerd, cri-o): a trailing
+// "<prefix->?<64 hex chars>" component, optionally followed by ".scope".
+var containerIDPattern = regexp.MustCompile(`(?:^|[-/])([0-9a-f]{64})(?:\.scope)?$`)
+
+// podUIDPattern matches the pod UID kubelet embeds in the cgroup path as
+// "kubepods-besteffort-pod<uid-with-underscores>.slice" or
+// "pod<uid-with-dashes>" depending on cgroup driver.
+var podUIDPattern = regexp.MustCompile(`pod([0-9a-f]{8}[_-][0-9a-f]{4}[_-][0-9a-f]{4}[_-][0-9a-f]{4}[_-][0-9a-f]{12})`)
+
+// ProcResolver derives a container ID and pod UID by reading
+// /proc/<pid>/cgroup, without talking to a container runtime. It cannot
+// recover a pod's name/namespace or the container's name, since those
+// aren't encoded in the cgroup path; wrap it in a ComposedResolver with a
+// RuntimeResolver (e.g. DockerResolver) to fill those in from the
+// container ID.
+type ProcResolver struct {
+	// procRoot defaults to "/proc" and is only overridden in tests.
+	procRoot string
+}
+
+// NewProcResolver returns a Resolver that attributes tgids by reading
+// /proc/<tgid>/cgroup.
+func NewProcResolver() *ProcResolver {
+	return &ProcResolver{procRoot: "/proc"}
+}
+
+// Resolve implements Resolver.
+func (r *ProcResolver) Resolve(tgid uint32) (Attribution, bool) {
+	path := fmt.Sprintf("%s/%d/cgroup", r.procRoot, tgid)
+	f, err := os.Open(path)
+	if err != nil {
+		return Attribution{}, false
+	}
+	defer f.Close()
+
+	var attribution Attribution
+	found := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if m := containerIDPattern.FindStringSubmatch(line); m != nil {
+			attribution.ContainerID = m[1]
+			found = true
+		}
+		if m := podUIDPattern.FindStringSubmatch(line); m != nil {
+			attribution.PodUID = normalizePodUID(m[1])
+			found = true
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return Attribution{}, false
+	}
+
+	return attribution, found
+}
+
+// normalizePodUID converts the underscore-separated UID kubelet uses for
+// the systemd cgroup driver back into the canonical dashed UUID form.
+func normalizePodUID(raw string) string {
+	out := []byte(raw)
+	for i, b := range out {
+		if b == '_' {
+			out[i] = '-'
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,32 @@
+// Package containers attributes RMIDs to the container and pod that owns
+// them, so rows in the Parquet output carry a human-readable identity
+// alongside the opaque rmid. Attribution happens once, when an RMID is
+// allocated to a task, and the result is cached in memory so later lookups
+// are a map read rather than another Resolver call.
+package containers
+
+// Attribution is everything we know about the workload a tgid belongs to.
+// Any field may be empty if the resolver couldn't determine it (e.g. the
+// process isn't in a container at all).
+type Attribution struct {
+	ContainerID   string
+	ContainerName string
+	PodName       string
+	PodNamespace  string
+	PodUID        string
+}
+
+// Empty reports whether a has no attribution at all.
+func (a Attribution) Empty() bool {
+	return a == Attribution{}
+}
+
+// Resolver attributes a tgid to the container/pod it runs in. Implementations
+// must be safe for concurrent use: Resolve is called from the RMID-alloc
+// path, which may run concurrently with the resolver's own background
+// bookkeeping (e.g. watching container runtime events).
+type Resolver interface {
+	// Resolve returns the attribution for tgid, and ok=false if none is
+	// known (e.g. tgid is not part of any container).
+	Resolve(tgid uint32) (attribution Attribution, ok bool)
+}
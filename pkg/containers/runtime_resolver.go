@@ -0,0 +1,53 @@
+package containers
+
+// RuntimeResolver supplements a container ID (recovered from a tgid's
+// cgroup path by a Resolver like ProcResolver) with the human-readable
+// names that only the container runtime itself knows: the pod's name and
+// namespace, and the container's name within that pod.
+type RuntimeResolver interface {
+	// ResolveContainer returns what the runtime knows about containerID,
+	// and ok=false if the runtime has no record of it (e.g. it already
+	// exited, or containerID belongs to a container outside Kubernetes).
+	ResolveContainer(containerID string) (Attribution, bool)
+}
+
+// ComposedResolver resolves a tgid's container ID and pod UID the same way
+// base does, then asks runtime to fill in the pod/container names base
+// can't recover on its own (they aren't encoded in the cgroup path).
+type ComposedResolver struct {
+	base    Resolver
+	runtime RuntimeResolver
+}
+
+// NewComposedResolver returns a Resolver that layers runtime's names onto
+// base's attribution. runtime may be nil, in which case ComposedResolver
+// behaves exactly like base.
+func NewComposedResolver(base Resolver, runtime RuntimeResolver) *ComposedResolver {
+	return &ComposedResolver{base: base, runtime: runtime}
+}
+
+// Resolve implements Resolver.
+func (r *ComposedResolver) Resolve(tgid uint32) (Attribution, bool) {
+	attribution, ok := r.base.Resolve(tgid)
+	if !ok {
+		return Attribution{}, false
+	}
+
+	if r.runtime == nil || attribution.ContainerID == "" {
+		return attribution, true
+	}
+
+	if names, ok := r.runtime.ResolveContainer(attribution.ContainerID); ok {
+		if names.ContainerName != "" {
+			attribution.ContainerName = names.ContainerName
+		}
+		if names.PodName != "" {
+			attribution.PodName = names.PodName
+		}
+		if names.PodNamespace != "" {
+			attribution.PodNamespace = names.PodNamespace
+		}
+	}
+
+	return attribution, true
+}
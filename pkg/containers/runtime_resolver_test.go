@@ -0,0 +1,69 @@
+package containers
+
+import "testing"
+
+type fakeRuntimeResolver struct {
+	byContainerID map[string]Attribution
+}
+
+func (r *fakeRuntimeResolver) ResolveContainer(containerID string) (Attribution, bool) {
+	attribution, ok := r.byContainerID[containerID]
+	return attribution, ok
+}
+
+func TestComposedResolverFillsInNamesFromRuntime(t *testing.T) {
+	base := NewFakeResolver()
+	base.Set(7, Attribution{ContainerID: "abc123", PodUID: "uid-1"})
+
+	runtime := &fakeRuntimeResolver{byContainerID: map[string]Attribution{
+		"abc123": {ContainerName: "web", PodName: "my-pod", PodNamespace: "default"},
+	}}
+
+	resolver := NewComposedResolver(base, runtime)
+	attribution, ok := resolver.Resolve(7)
+	if !ok {
+		t.Fatal("expected Resolve to succeed")
+	}
+	if attribution.ContainerID != "abc123" || attribution.PodUID != "uid-1" {
+		t.Fatalf("expected base's container id/pod uid to be preserved, got %+v", attribution)
+	}
+	if attribution.ContainerName != "web" || attribution.PodName != "my-pod" || attribution.PodNamespace != "default" {
+		t.Fatalf("expected runtime names to be filled in, got %+v", attribution)
+	}
+}
+
+func TestComposedResolverNilRuntimeBehavesLikeBase(t *testing.T) {
+	base := NewFakeResolver()
+	base.Set(7, Attribution{ContainerID: "abc123"})
+
+	resolver := NewComposedResolver(base, nil)
+	attribution, ok := resolver.Resolve(7)
+	if !ok || attribution.ContainerID != "abc123" {
+		t.Fatalf("expected base's attribution unchanged, got %+v (ok=%v)", attribution, ok)
+	}
+}
+
+func TestComposedResolverUnknownContainerLeavesNamesEmpty(t *testing.T) {
+	base := NewFakeResolver()
+	base.Set(7, Attribution{ContainerID: "unknown-to-runtime"})
+
+	runtime := &fakeRuntimeResolver{byContainerID: map[string]Attribution{}}
+
+	resolver := NewComposedResolver(base, runtime)
+	attribution, ok := resolver.Resolve(7)
+	if !ok {
+		t.Fatal("expected Resolve to still succeed using base's attribution alone")
+	}
+	if attribution.ContainerName != "" || attribution.PodName != "" {
+		t.Fatalf("expected no names filled in for a container the runtime doesn't know, got %+v", attribution)
+	}
+}
+
+func TestComposedResolverBaseMissResolveFails(t *testing.T) {
+	base := NewFakeResolver()
+	resolver := NewComposedResolver(base, &fakeRuntimeResolver{})
+
+	if _, ok := resolver.Resolve(99); ok {
+		t.Fatal("expected Resolve to fail when base has no attribution for the tgid")
+	}
+}
@@ -0,0 +1,122 @@
+package sink
+
+import (
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+// bufferedBatchLen bounds how many completed-slot batches a BufferedSink
+// will queue for its underlying sink before it starts dropping the oldest
+// queued batch to make room for the newest one.
+const bufferedBatchLen = 64
+
+// BufferedSink puts a bounded queue between WriteSlots and a downstream
+// Sink that may be slow or stalled. Once the queue is full, the oldest
+// queued batch is dropped to make room for the new one and the
+// dropped-batches counter is incremented, so operators can see a sink
+// falling behind instead of silently losing data.
+type BufferedSink struct {
+	underlying Sink
+	queue      chan []*aggregate.TimeSlot
+	dropped    prometheus.Counter
+
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// NewBufferedSink wraps underlying with a bounded queue of batches. name
+// labels the dropped-batches metric (e.g. "parquet", "otlp") so multiple
+// buffered sinks can be told apart once registered.
+func NewBufferedSink(name string, underlying Sink) *BufferedSink {
+	bs := &BufferedSink{
+		underlying: underlying,
+		queue:      make(chan []*aggregate.TimeSlot, bufferedBatchLen),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace:   "collector",
+			Subsystem:   "sink",
+			Name:        "dropped_batches_total",
+			Help:        "Batches of time slots dropped because this sink's buffer was full.",
+			ConstLabels: prometheus.Labels{"sink": name},
+		}),
+		done: make(chan struct{}),
+	}
+	bs.wg.Add(1)
+	go bs.run()
+	return bs
+}
+
+// Collector exposes the dropped-batches counter so callers can register it
+// with a prometheus.Registerer alongside the rest of the collector's
+// metrics.
+func (bs *BufferedSink) Collector() prometheus.Collector {
+	return bs.dropped
+}
+
+// WriteSlots implements Sink. If the queue is full, the oldest queued
+// batch is dropped to make room for slots rather than waiting for the
+// underlying sink to catch up.
+func (bs *BufferedSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	select {
+	case bs.queue <- slots:
+		return nil
+	default:
+	}
+
+	select {
+	case <-bs.queue:
+		bs.dropped.Inc()
+	default:
+	}
+
+	select {
+	case bs.queue <- slots:
+	default:
+		// Another writer raced us and refilled the queue; drop this batch
+		// rather than block the caller.
+		bs.dropped.Inc()
+	}
+	return nil
+}
+
+func (bs *BufferedSink) run() {
+	defer bs.wg.Done()
+	for {
+		select {
+		case batch := <-bs.queue:
+			bs.write(batch)
+		case <-bs.done:
+			bs.drain()
+			return
+		}
+	}
+}
+
+func (bs *BufferedSink) drain() {
+	for {
+		select {
+		case batch := <-bs.queue:
+			bs.write(batch)
+		default:
+			return
+		}
+	}
+}
+
+func (bs *BufferedSink) write(batch []*aggregate.TimeSlot) {
+	if err := bs.underlying.WriteSlots(batch); err != nil {
+		// Sinks are best-effort from the hot path's perspective: log and
+		// move on rather than let one bad batch wedge the queue.
+		log.Printf("sink %T: error writing batch: %v", bs.underlying, err)
+	}
+}
+
+// Close stops accepting new batches, drains the queue into the underlying
+// sink, and closes it.
+func (bs *BufferedSink) Close() error {
+	close(bs.done)
+	bs.wg.Wait()
+	return bs.underlying.Close()
+}
@@ -0,0 +1,143 @@
+package sink
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+// blockingSink blocks every WriteSlots call until release is closed, so
+// tests can force BufferedSink's queue to fill up.
+type blockingSink struct {
+	release chan struct{}
+
+	mu      sync.Mutex
+	written [][]*aggregate.TimeSlot
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{release: make(chan struct{})}
+}
+
+func (s *blockingSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	<-s.release
+	s.mu.Lock()
+	s.written = append(s.written, slots)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func batch(tag uint32) []*aggregate.TimeSlot {
+	return []*aggregate.TimeSlot{{StartTime: uint64(tag), EndTime: uint64(tag)}}
+}
+
+func TestBufferedSinkDropsOldestWhenFull(t *testing.T) {
+	underlying := newBlockingSink()
+	bs := NewBufferedSink("test", underlying)
+	defer func() {
+		// The test body closes release itself once it gets that far.
+		select {
+		case <-underlying.release:
+		default:
+			close(underlying.release)
+		}
+		bs.Close()
+	}()
+
+	// The first WriteSlots call is immediately picked up by bs.run and
+	// blocks on underlying, so the queue behind it fills up from the
+	// second call onward.
+	if err := bs.WriteSlots(batch(0)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // let bs.run dequeue it into the blocking call
+
+	// Fill the queue (tags 1..bufferedBatchLen) then overflow it by one
+	// (tag bufferedBatchLen+1), which should drop the oldest queued batch
+	// (tag 1) rather than the newest.
+	for i := 1; i <= bufferedBatchLen+1; i++ {
+		if err := bs.WriteSlots(batch(uint32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got := testutil.ToFloat64(bs.Collector().(prometheus.Counter)); got != 1 {
+		t.Fatalf("expected exactly 1 dropped batch once the queue overflowed, got %v", got)
+	}
+
+	// Unblock the underlying sink and let everything still queued drain.
+	close(underlying.release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		underlying.mu.Lock()
+		n := len(underlying.written)
+		underlying.mu.Unlock()
+		if n == bufferedBatchLen+1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for queued batches to drain, got %d", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The oldest queued batch (tag 1) should have been the one dropped,
+	// so the surviving batches should start at tag 2.
+	underlying.mu.Lock()
+	defer underlying.mu.Unlock()
+	if underlying.written[1][0].StartTime != 2 {
+		t.Fatalf("expected the oldest queued batch to be dropped, first surviving tag was %d", underlying.written[1][0].StartTime)
+	}
+}
+
+func TestBufferedSinkCloseDrainsQueue(t *testing.T) {
+	underlying := &recordingSink{}
+	bs := NewBufferedSink("test", underlying)
+
+	for i := 0; i < 5; i++ {
+		if err := bs.WriteSlots(batch(uint32(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	underlying.mu.Lock()
+	defer underlying.mu.Unlock()
+	if len(underlying.written) != 5 {
+		t.Fatalf("expected Close to drain all 5 queued batches, got %d", len(underlying.written))
+	}
+	if !underlying.closed {
+		t.Fatal("expected Close to close the underlying sink")
+	}
+}
+
+// recordingSink records every batch it receives, for tests that don't need
+// to force backpressure.
+type recordingSink struct {
+	mu      sync.Mutex
+	written [][]*aggregate.TimeSlot
+	closed  bool
+}
+
+func (s *recordingSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.written = append(s.written, slots)
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
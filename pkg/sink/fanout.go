@@ -0,0 +1,34 @@
+package sink
+
+import (
+	"errors"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+// Fanout writes every batch to each of its sinks in turn, so e.g. a Parquet
+// sink and an OTLP sink can run side by side off the same aggregator. A
+// failure in one sink does not stop the others from receiving the batch.
+type Fanout []Sink
+
+// WriteSlots implements Sink.
+func (f Fanout) WriteSlots(slots []*aggregate.TimeSlot) error {
+	var errs []error
+	for _, s := range f {
+		if err := s.WriteSlots(slots); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close implements Sink.
+func (f Fanout) Close() error {
+	var errs []error
+	for _, s := range f {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
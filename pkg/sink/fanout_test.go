@@ -0,0 +1,66 @@
+package sink
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+type stubSink struct {
+	writeErr error
+	closeErr error
+
+	writes int
+	closes int
+}
+
+func (s *stubSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	s.writes++
+	return s.writeErr
+}
+
+func (s *stubSink) Close() error {
+	s.closes++
+	return s.closeErr
+}
+
+func TestFanoutWritesToEverySink(t *testing.T) {
+	a, b := &stubSink{}, &stubSink{}
+	f := Fanout{a, b}
+
+	if err := f.WriteSlots(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.writes != 1 || b.writes != 1 {
+		t.Fatalf("expected both sinks to receive the batch, got %d and %d", a.writes, b.writes)
+	}
+}
+
+func TestFanoutWriteSlotsOneSinkErrorDoesNotStopOthers(t *testing.T) {
+	failing := &stubSink{writeErr: errors.New("boom")}
+	ok := &stubSink{}
+	f := Fanout{failing, ok}
+
+	err := f.WriteSlots(nil)
+	if err == nil {
+		t.Fatal("expected an error to be returned")
+	}
+	if ok.writes != 1 {
+		t.Fatal("expected the second sink to still receive the batch")
+	}
+}
+
+func TestFanoutCloseClosesEverySink(t *testing.T) {
+	a := &stubSink{closeErr: errors.New("a failed")}
+	b := &stubSink{}
+	f := Fanout{a, b}
+
+	err := f.Close()
+	if err == nil {
+		t.Fatal("expected an error to be returned")
+	}
+	if a.closes != 1 || b.closes != 1 {
+		t.Fatalf("expected both sinks to be closed, got %d and %d", a.closes, b.closes)
+	}
+}
@@ -0,0 +1,77 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+// OTLPSink exports completed time slots as OTLP metrics over gRPC, one
+// gauge data point per (rmid, counter) pair per slot.
+type OTLPSink struct {
+	exporter *otlpmetricgrpc.Exporter
+}
+
+// NewOTLPSink dials endpoint (host:port) and returns a Sink that exports
+// every batch of completed time slots to it.
+func NewOTLPSink(ctx context.Context, endpoint string) (*OTLPSink, error) {
+	exporter, err := otlpmetricgrpc.New(ctx,
+		otlpmetricgrpc.WithEndpoint(endpoint),
+		otlpmetricgrpc.WithInsecure(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP exporter for %s: %w", endpoint, err)
+	}
+	return &OTLPSink{exporter: exporter}, nil
+}
+
+// WriteSlots implements Sink.
+func (s *OTLPSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	rm := &metricdata.ResourceMetrics{
+		ScopeMetrics: []metricdata.ScopeMetrics{{
+			Metrics: []metricdata.Metrics{
+				gaugeMetric("collector.cycles", slots, func(agg aggregate.Aggregation) float64 { return float64(agg.Cycles) }),
+				gaugeMetric("collector.instructions", slots, func(agg aggregate.Aggregation) float64 { return float64(agg.Instructions) }),
+				gaugeMetric("collector.llc_misses", slots, func(agg aggregate.Aggregation) float64 { return float64(agg.LLCMisses) }),
+			},
+		}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := s.exporter.Export(ctx, rm); err != nil {
+		return fmt.Errorf("exporting to OTLP endpoint: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (s *OTLPSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.exporter.Shutdown(ctx)
+}
+
+func gaugeMetric(name string, slots []*aggregate.TimeSlot, value func(aggregate.Aggregation) float64) metricdata.Metrics {
+	var points []metricdata.DataPoint[float64]
+	for _, slot := range slots {
+		for rmid, agg := range slot.Aggregations {
+			points = append(points, metricdata.DataPoint[float64]{
+				Attributes: attribute.NewSet(attribute.Int64("rmid", int64(rmid))),
+				StartTime:  time.Unix(0, int64(slot.StartTime)),
+				Time:       time.Unix(0, int64(slot.EndTime)),
+				Value:      value(agg),
+			})
+		}
+	}
+	return metricdata.Metrics{
+		Name: name,
+		Data: metricdata.Gauge[float64]{DataPoints: points},
+	}
+}
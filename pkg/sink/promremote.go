@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+// PromRemoteWriteSink pushes completed time slots to a Prometheus
+// remote-write endpoint, one gauge time series per (rmid, counter) pair.
+type PromRemoteWriteSink struct {
+	endpoint string
+	timeout  time.Duration
+	client   *http.Client
+}
+
+// NewPromRemoteWriteSink returns a Sink that POSTs to a Prometheus
+// remote-write endpoint (e.g. "http://localhost:9090/api/v1/write").
+// timeout bounds every POST so a stalled endpoint can never block the
+// caller indefinitely.
+func NewPromRemoteWriteSink(endpoint string, timeout time.Duration, client *http.Client) *PromRemoteWriteSink {
+	if client == nil {
+		client = &http.Client{Timeout: timeout}
+	}
+	return &PromRemoteWriteSink{endpoint: endpoint, timeout: timeout, client: client}
+}
+
+// WriteSlots implements Sink.
+func (s *PromRemoteWriteSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	req := &prompb.WriteRequest{}
+
+	for _, slot := range slots {
+		timestampMs := int64(slot.EndTime / 1_000_000)
+		for rmid, agg := range slot.Aggregations {
+			req.Timeseries = append(req.Timeseries,
+				series("collector_cycles_total", rmid, float64(agg.Cycles), timestampMs),
+				series("collector_instructions_total", rmid, float64(agg.Instructions), timestampMs),
+				series("collector_llc_misses_total", rmid, float64(agg.LLCMisses), timestampMs),
+			)
+		}
+	}
+
+	if len(req.Timeseries) == 0 {
+		return nil
+	}
+
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshaling remote-write request: %w", err)
+	}
+	compressed := snappy.Encode(nil, data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(compressed))
+	if err != nil {
+		return fmt.Errorf("building remote-write request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("posting to %s: %w", s.endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("remote-write to %s: unexpected status %s", s.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// Close implements Sink. The underlying http.Client has no per-sink state
+// to release.
+func (s *PromRemoteWriteSink) Close() error {
+	return nil
+}
+
+func series(name string, rmid uint32, value float64, timestampMs int64) prompb.TimeSeries {
+	return prompb.TimeSeries{
+		Labels: []prompb.Label{
+			{Name: "__name__", Value: name},
+			{Name: "rmid", Value: strconv.FormatUint(uint64(rmid), 10)},
+		},
+		Samples: []prompb.Sample{
+			{Value: value, Timestamp: timestampMs},
+		},
+	}
+}
@@ -0,0 +1,16 @@
+// Package sink defines the output side of the collector's aggregation
+// pipeline: a Sink receives batches of completed time slots and decides how
+// to export them (a local Parquet file, an OTLP or Prometheus remote-write
+// endpoint, a line-oriented TCP stream, ...). Sinks are composable via
+// Fanout and are expected to be wrapped in a BufferedSink so a slow or
+// stalled downstream can never back up the aggregator that feeds them.
+package sink
+
+import "github.com/unvariance/collector/pkg/aggregate"
+
+// Sink receives a batch of completed time slots at a time, rather than one
+// row at a time, so it can make its own batching/compression decisions.
+type Sink interface {
+	WriteSlots(slots []*aggregate.TimeSlot) error
+	Close() error
+}
@@ -0,0 +1,82 @@
+package sink
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unvariance/collector/pkg/aggregate"
+)
+
+// TCPSink writes three lines per (time slot, rmid) pair to a TCP connection,
+// one per counter, in the carbon/influx line-protocol style: a metric path,
+// its value, and a timestamp, space separated. It reconnects lazily on the next write
+// after a connection error.
+type TCPSink struct {
+	addr         string
+	writeTimeout time.Duration
+	dialTimeout  time.Duration
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewTCPSink returns a Sink that writes to addr over TCP. writeTimeout
+// bounds every write (and the connection attempt before it) so a stalled
+// downstream can never block the caller indefinitely.
+func NewTCPSink(addr string, writeTimeout time.Duration) *TCPSink {
+	return &TCPSink{
+		addr:         addr,
+		writeTimeout: writeTimeout,
+		dialTimeout:  writeTimeout,
+	}
+}
+
+// WriteSlots implements Sink.
+func (s *TCPSink) WriteSlots(slots []*aggregate.TimeSlot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.addr, s.dialTimeout)
+		if err != nil {
+			return fmt.Errorf("dialing %s: %w", s.addr, err)
+		}
+		s.conn = conn
+	}
+
+	if err := s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout)); err != nil {
+		return fmt.Errorf("setting write deadline: %w", err)
+	}
+
+	for _, slot := range slots {
+		for rmid, agg := range slot.Aggregations {
+			line := fmt.Sprintf(
+				"collector.rmid.%d.cycles %d %d\ncollector.rmid.%d.instructions %d %d\ncollector.rmid.%d.llc_misses %d %d\n",
+				rmid, agg.Cycles, slot.EndTime,
+				rmid, agg.Instructions, slot.EndTime,
+				rmid, agg.LLCMisses, slot.EndTime,
+			)
+			if _, err := s.conn.Write([]byte(line)); err != nil {
+				s.conn.Close()
+				s.conn = nil
+				return fmt.Errorf("writing to %s: %w", s.addr, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Close implements Sink.
+func (s *TCPSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}